@@ -7,12 +7,17 @@ package dynamodbstore
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/base32"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/securecookie"
@@ -21,6 +26,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
 var sessionExpire = 86400 * 30
@@ -52,14 +58,214 @@ func (s GobSerializer) Deserialize(d []byte, ss *sessions.Session) error {
 	return dec.Decode(&ss.Values)
 }
 
+// JSONSerializer encodes the session map as JSON, so the stored item is
+// readable from languages other than Go. Only string keys are supported,
+// since JSON object keys must be strings.
+//
+// JSON has no integer type, so any numeric value put in Values comes back
+// out of Deserialize as a float64 rather than its original Go type (and
+// loses precision above 2^53 for values that started out as int64/uint64,
+// e.g. snowflake IDs or nanosecond timestamps). Code that does
+// session.Values["x"].(int) under the default GobSerializer will panic or
+// fail its type assertion once JSONSerializer is in use; store numbers as
+// strings, or read them back as float64, if this matters.
+type JSONSerializer struct{}
+
+// Serialize to JSON. Returns an error if ss.Values contains a non-string key.
+func (s JSONSerializer) Serialize(ss *sessions.Session) ([]byte, error) {
+	m := make(map[string]interface{}, len(ss.Values))
+	for k, v := range ss.Values {
+		ks, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("dynamodbstore: JSONSerializer cannot serialize non-string key %v", k)
+		}
+		m[ks] = v
+	}
+	return json.Marshal(m)
+}
+
+// Deserialize back to map[interface{}]interface{}. See the JSONSerializer
+// doc comment for the numeric type-widening caveat this introduces.
+func (s JSONSerializer) Deserialize(d []byte, ss *sessions.Session) error {
+	m := make(map[string]interface{})
+	if err := json.Unmarshal(d, &m); err != nil {
+		return err
+	}
+	for k, v := range m {
+		ss.Values[k] = v
+	}
+	return nil
+}
+
+// encryptedSerializerCookieName is the fixed name passed to securecookie
+// when encoding/decoding the serialized data blob. It never appears in a
+// browser cookie - it is only used to key the securecookie AEAD.
+const encryptedSerializerCookieName = "dynamodbstore-data"
+
+// EncryptedSerializer wraps another SessionSerializer and encrypts its
+// output with securecookie before it is written to DynamoDB, so operators
+// storing PII in sessions get server-side encryption independent of the
+// DynamoDB table's KMS configuration.
+type EncryptedSerializer struct {
+	Serializer SessionSerializer
+	Codecs     []securecookie.Codec
+}
+
+// NewEncryptedSerializer wraps serializer, encrypting/authenticating its
+// output with the given securecookie key pairs. See
+// securecookie.CodecsFromPairs for the expected key pair format.
+//
+// securecookie.CodecsFromPairs codecs default to a 30 day MaxAge, which
+// would make Deserialize fail once a row's "data" blob (not just its
+// "expires" attribute) is older than that - exactly the case for a
+// long-lived row kept alive by the RefreshInterval fast path, which only
+// touches "expires" and never re-encrypts "data". Session lifetime is
+// already governed by the "expires" attribute, so the codecs' own MaxAge
+// check is disabled here.
+func NewEncryptedSerializer(serializer SessionSerializer, keyPairs ...[]byte) *EncryptedSerializer {
+	codecs := securecookie.CodecsFromPairs(keyPairs...)
+	for _, c := range codecs {
+		if sc, ok := c.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(0)
+		}
+	}
+	return &EncryptedSerializer{
+		Serializer: serializer,
+		Codecs:     codecs,
+	}
+}
+
+// Serialize via the wrapped serializer, then encrypt the result.
+func (s *EncryptedSerializer) Serialize(ss *sessions.Session) ([]byte, error) {
+	b, err := s.Serializer.Serialize(ss)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := securecookie.EncodeMulti(encryptedSerializerCookieName, b, s.Codecs...)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(encoded), nil
+}
+
+// Deserialize decrypts d, then hands the plaintext to the wrapped serializer.
+func (s *EncryptedSerializer) Deserialize(d []byte, ss *sessions.Session) error {
+	var b []byte
+	if err := securecookie.DecodeMulti(encryptedSerializerCookieName, string(d), &b, s.Codecs...); err != nil {
+		return err
+	}
+	return s.Serializer.Deserialize(b, ss)
+}
+
+// DynamoAPI is the DynamoDB client interface DynamoDBStore depends on. It
+// is an alias for the full dynamodbiface.DynamoDBAPI, not a hand-picked
+// subset: DynamoDBStore.Db is exported specifically so callers can reach
+// through it for operations the store itself doesn't use (Query,
+// BatchWriteItem, etc.), and a narrower interface would silently break
+// that existing usage. Fakes for testing typically embed
+// dynamodbiface.DynamoDBAPI and only override the handful of methods
+// actually exercised - see dynamodbstore_test.go.
+type DynamoAPI = dynamodbiface.DynamoDBAPI
+
 // DynamoDBStore stores sessions in a DynamoDB table.
 type DynamoDBStore struct {
-	Db            *dynamodb.DynamoDB
+	Db            DynamoAPI
 	Table         string
 	Codecs        []securecookie.Codec
 	Options       *sessions.Options // default configuration
 	DefaultMaxAge int               // default TTL for a MaxAge == 0 session
 	serializer    SessionSerializer
+
+	// RefreshInterval enables the rolling-session optimisation in save():
+	// if a loaded session's "expires" is still more than RefreshInterval in
+	// the future and its Values were not modified, save() only touches the
+	// "expires" attribute instead of rewriting the whole item. Zero disables
+	// the optimisation.
+	RefreshInterval time.Duration
+
+	loadedMu sync.Mutex
+	loaded   map[string]loadedSession
+}
+
+// loadedSession records what was read back in loadContext for a session ID,
+// so saveContext can tell whether Values actually changed since load.
+type loadedSession struct {
+	hash    [sha256.Size]byte
+	expires time.Time
+}
+
+// stableHash hashes a session's Values independently of key iteration
+// order, so it can be compared across two reads of an unmodified map.
+// It cannot use the serializer's own output for this: gob.Encode of a
+// map[interface{}]interface{} walks the map in Go's randomized iteration
+// order, so two encodes of the same unchanged map produce different byte
+// strings. Here keys are instead sorted by their formatted representation
+// before each key/value pair is fed to gob individually, which is
+// deterministic.
+func stableHash(values map[interface{}]interface{}) ([sha256.Size]byte, error) {
+	keys := make([]string, 0, len(values))
+	byKey := make(map[string]interface{}, len(values))
+	for k := range values {
+		ks := fmt.Sprintf("%#v", k)
+		keys = append(keys, ks)
+		byKey[ks] = k
+	}
+	sort.Strings(keys)
+
+	buf := new(bytes.Buffer)
+	enc := gob.NewEncoder(buf)
+	for _, ks := range keys {
+		k := byKey[ks]
+		if err := enc.Encode(&k); err != nil {
+			return [sha256.Size]byte{}, err
+		}
+		v := values[k]
+		if err := enc.Encode(&v); err != nil {
+			return [sha256.Size]byte{}, err
+		}
+	}
+	return sha256.Sum256(buf.Bytes()), nil
+}
+
+// StoreOptions controls how NewDynamoDBStoreWithOptions provisions the
+// session table and schedules expiration of stale rows.
+type StoreOptions struct {
+	// ReadCapacityUnits and WriteCapacityUnits are used when the table is
+	// created and BillingMode is left empty (provisioned throughput mode).
+	ReadCapacityUnits  int64
+	WriteCapacityUnits int64
+
+	// BillingMode selects the table's billing mode when it is created, e.g.
+	// dynamodb.BillingModePayPerRequest. Leave empty for provisioned
+	// throughput using ReadCapacityUnits/WriteCapacityUnits.
+	BillingMode string
+
+	// EnableTTL enables DynamoDB's native Time To Live feature on the
+	// "expires" attribute so expired rows are reclaimed by DynamoDB itself
+	// instead of (or in addition to) the background sweep below. Note that
+	// DynamoDB's TTL deletion can lag up to 48 hours, so load() still
+	// guards against stale rows regardless of this setting.
+	EnableTTL bool
+
+	// BackgroundSweep controls whether a goroutine is started to
+	// periodically Scan the table and delete expired sessions. Disable
+	// this when EnableTTL is set and native TTL deletion is sufficient.
+	BackgroundSweep bool
+
+	// SweepInterval is how often the background sweep runs when
+	// BackgroundSweep is true. Defaults to expireScanInterval (24h) when
+	// zero.
+	SweepInterval time.Duration
+
+	// Serializer selects the SessionSerializer used to encode session
+	// Values, e.g. JSONSerializer, GobSerializer (the default), or an
+	// EncryptedSerializer wrapping either. Defaults to GobSerializer{}
+	// when nil.
+	Serializer SessionSerializer
+
+	// RefreshInterval is copied onto the returned DynamoDBStore. See
+	// DynamoDBStore.RefreshInterval.
+	RefreshInterval time.Duration
 }
 
 // SetSerializer sets the serializer
@@ -93,12 +299,33 @@ func (s *DynamoDBStore) SetMaxAge(v int) {
 // NewDynamoDBStore returns a new DynamoDBStore.
 // sessionCookieExpireTime controls how long a session will live in the database when it is set to 0 seconds expire time aka session cookie
 // sessionTableName is name of dynamodb table to use - is created if it does not exist. If created, readCapacityUnits and writeCapacityUnits are used
-func NewDynamoDBStore(db *dynamodb.DynamoDB, sessionCookieExpireTime int, sessionTableName string, readCapacityUnits int64, writeCapacityUnits int64, keyPairs ...[]byte) (*DynamoDBStore, error) {
+func NewDynamoDBStore(db DynamoAPI, sessionCookieExpireTime int, sessionTableName string, readCapacityUnits int64, writeCapacityUnits int64, keyPairs ...[]byte) (*DynamoDBStore, error) {
+
+	return NewDynamoDBStoreWithOptions(db, sessionCookieExpireTime, sessionTableName, StoreOptions{
+		ReadCapacityUnits:  readCapacityUnits,
+		WriteCapacityUnits: writeCapacityUnits,
+		BackgroundSweep:    true,
+		SweepInterval:      expireScanInterval,
+	}, keyPairs...)
+
+}
 
-	if err := tryCreateTable(db, sessionTableName, readCapacityUnits, writeCapacityUnits); err != nil {
+// NewDynamoDBStoreWithOptions returns a new DynamoDBStore, like
+// NewDynamoDBStore, but exposes additional knobs via options: enabling
+// DynamoDB's native TTL feature, choosing the table's billing mode, and
+// disabling or retuning the background expiration sweep in favour of
+// relying on TTL alone.
+func NewDynamoDBStoreWithOptions(db DynamoAPI, sessionCookieExpireTime int, sessionTableName string, options StoreOptions, keyPairs ...[]byte) (*DynamoDBStore, error) {
+
+	if err := tryCreateTable(db, sessionTableName, options); err != nil {
 		return nil, err
 	}
 
+	serializer := options.Serializer
+	if serializer == nil {
+		serializer = GobSerializer{}
+	}
+
 	newDynamoDBStore := &DynamoDBStore{
 		Db:     db,
 		Table:  sessionTableName,
@@ -107,18 +334,27 @@ func NewDynamoDBStore(db *dynamodb.DynamoDB, sessionCookieExpireTime int, sessio
 			Path:   "/",
 			MaxAge: sessionExpire,
 		},
-		DefaultMaxAge: sessionCookieExpireTime,
-		serializer:    GobSerializer{},
+		DefaultMaxAge:   sessionCookieExpireTime,
+		serializer:      serializer,
+		RefreshInterval: options.RefreshInterval,
+		loaded:          make(map[string]loadedSession),
 	}
 
-	go newDynamoDBStore.runBackgroundExpiration()
+	if options.BackgroundSweep {
+		sweepInterval := options.SweepInterval
+		if sweepInterval == 0 {
+			sweepInterval = expireScanInterval
+		}
+		go newDynamoDBStore.PeriodicCleanup(sweepInterval, nil)
+	}
 
 	return newDynamoDBStore, nil
 
 }
 
-// make sure session table exists
-func tryCreateTable(db *dynamodb.DynamoDB, sessionTableName string, readCapacityUnits, writeCapacityUnits int64) error {
+// make sure session table exists, creating it (with TTL enabled when
+// requested) if necessary
+func tryCreateTable(db DynamoAPI, sessionTableName string, options StoreOptions) error {
 
 	describeTableInput := &dynamodb.DescribeTableInput{TableName: aws.String(sessionTableName)}
 	if _, err := db.DescribeTable(describeTableInput); err != nil {
@@ -140,10 +376,15 @@ func tryCreateTable(db *dynamodb.DynamoDB, sessionTableName string, readCapacity
 						KeyType:       aws.String("HASH"), // Required
 					},
 				},
-				ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
-					ReadCapacityUnits:  aws.Int64(readCapacityUnits),
-					WriteCapacityUnits: aws.Int64(writeCapacityUnits),
-				},
+			}
+
+			if options.BillingMode == dynamodb.BillingModePayPerRequest {
+				params.BillingMode = aws.String(dynamodb.BillingModePayPerRequest)
+			} else {
+				params.ProvisionedThroughput = &dynamodb.ProvisionedThroughput{
+					ReadCapacityUnits:  aws.Int64(options.ReadCapacityUnits),
+					WriteCapacityUnits: aws.Int64(options.WriteCapacityUnits),
+				}
 			}
 
 			if _, err := db.CreateTable(params); err != nil {
@@ -155,6 +396,36 @@ func tryCreateTable(db *dynamodb.DynamoDB, sessionTableName string, readCapacity
 		}
 	}
 
+	if options.EnableTTL {
+		if err := enableTableTTL(db, sessionTableName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+// enableTableTTL turns on DynamoDB's native Time To Live feature on the
+// "expires" attribute. It is safe to call repeatedly - DynamoDB returns a
+// ValidationException when TTL is already enabled, which is ignored here.
+func enableTableTTL(db DynamoAPI, sessionTableName string) error {
+
+	_, err := db.UpdateTimeToLive(&dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(sessionTableName),
+		TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String("expires"),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		if awserr, ok := err.(awserr.Error); ok && awserr.Code() == "ValidationException" {
+			// already enabled (or already in the process of being enabled)
+			return nil
+		}
+		return err
+	}
+
 	return nil
 
 }
@@ -166,10 +437,25 @@ func (s *DynamoDBStore) Get(r *http.Request, name string) (*sessions.Session, er
 	return sessions.GetRegistry(r).Get(s, name)
 }
 
+// GetContext is like Get but threads ctx through to the underlying DynamoDB
+// calls, so callers can plumb request deadlines, tracing spans, or
+// cancellation. Unlike Get, it does not go through the gorilla/sessions
+// registry cache, since the registry's Store interface predates context
+// support.
+func (s *DynamoDBStore) GetContext(ctx context.Context, r *http.Request, name string) (*sessions.Session, error) {
+	return s.NewContext(ctx, r, name)
+}
+
 // New returns a session for the given name without adding it to the registry.
 //
 // See gorilla/sessions FilesystemStore.New().
 func (s *DynamoDBStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	return s.NewContext(context.Background(), r, name)
+}
+
+// NewContext is like New but threads ctx through to the underlying DynamoDB
+// calls.
+func (s *DynamoDBStore) NewContext(ctx context.Context, r *http.Request, name string) (*sessions.Session, error) {
 	var err error
 	session := sessions.NewSession(s, name)
 	// make a copy
@@ -179,7 +465,7 @@ func (s *DynamoDBStore) New(r *http.Request, name string) (*sessions.Session, er
 	if c, errCookie := r.Cookie(name); errCookie == nil {
 		err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...)
 		if err == nil {
-			ok, err := s.load(session)
+			ok, err := s.loadContext(ctx, session)
 			session.IsNew = !(err == nil && ok) // not new if no error and data available
 		}
 	}
@@ -188,9 +474,15 @@ func (s *DynamoDBStore) New(r *http.Request, name string) (*sessions.Session, er
 
 // Save adds a single session to the response.
 func (s *DynamoDBStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	return s.SaveContext(context.Background(), r, w, session)
+}
+
+// SaveContext is like Save but threads ctx through to the underlying
+// DynamoDB calls.
+func (s *DynamoDBStore) SaveContext(ctx context.Context, r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
 	// Marked for deletion.
 	if session.Options.MaxAge < 0 {
-		if err := s.delete(session.ID); err != nil {
+		if err := s.deleteContext(ctx, session.ID); err != nil {
 			return err
 		}
 		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
@@ -199,7 +491,7 @@ func (s *DynamoDBStore) Save(r *http.Request, w http.ResponseWriter, session *se
 		if session.ID == "" {
 			session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
 		}
-		if err := s.save(session); err != nil {
+		if err := s.saveContext(ctx, session); err != nil {
 			return err
 		}
 		encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
@@ -213,15 +505,45 @@ func (s *DynamoDBStore) Save(r *http.Request, w http.ResponseWriter, session *se
 
 // save stores the session in DB.
 func (s *DynamoDBStore) save(session *sessions.Session) error {
-	b, err := s.serializer.Serialize(session)
-	if err != nil {
-		return err
-	}
+	return s.saveContext(context.Background(), session)
+}
+
+// saveContext stores the session in DB, passing ctx through to the
+// underlying PutItem call. If RefreshInterval is set and the session is
+// still well short of expiring with Values unchanged since it was loaded,
+// it instead issues a conditional UpdateItem that only touches "expires".
+func (s *DynamoDBStore) saveContext(ctx context.Context, session *sessions.Session) error {
 	age := session.Options.MaxAge
 	// if session age == 0 (session cookie, still expire from DB after a period of DefaultMaxAge)
 	if age == 0 {
 		age = s.DefaultMaxAge
 	}
+	expires := time.Now().Add(time.Duration(age) * time.Second)
+
+	if s.RefreshInterval > 0 {
+		if loaded, ok := s.takeLoaded(session.ID); ok && time.Until(loaded.expires) > s.RefreshInterval {
+			if hash, err := stableHash(session.Values); err == nil && hash == loaded.hash {
+				err := s.touchExpiresContext(ctx, session.ID, expires)
+				if err == nil {
+					return nil
+				}
+				// The row may have been deleted concurrently (expired, or
+				// the user signed out elsewhere) between our GetItem and
+				// this UpdateItem; attribute_exists(id) then fails with
+				// ConditionalCheckFailedException. Fall through to the
+				// full PutItem rewrite below instead of surfacing that as
+				// a save error.
+				if awserr, ok := err.(awserr.Error); !ok || awserr.Code() != "ConditionalCheckFailedException" {
+					return err
+				}
+			}
+		}
+	}
+
+	b, err := s.serializer.Serialize(session)
+	if err != nil {
+		return err
+	}
 
 	putInput := &dynamodb.PutItemInput{
 		TableName: aws.String(s.Table),
@@ -234,19 +556,84 @@ func (s *DynamoDBStore) save(session *sessions.Session) error {
 			},
 			"expires": {
 				// add age seconds to now, convert to timestamp and convert to string.
-				N: aws.String(strconv.Itoa(int(time.Now().Add(time.Duration(age) * time.Second).Unix()))),
+				N: aws.String(strconv.Itoa(int(expires.Unix()))),
 			},
 		},
 	}
 
-	_, err = s.Db.PutItem(putInput)
+	_, err = s.Db.PutItemWithContext(ctx, putInput)
 
 	return err
 }
 
+// touchExpiresContext updates only the "expires" attribute of an existing
+// session row, skipping the far more expensive rewrite of "data".
+func (s *DynamoDBStore) touchExpiresContext(ctx context.Context, sessionID string, expires time.Time) error {
+	updateInput := &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.Table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(sessionID),
+			},
+		},
+		UpdateExpression: aws.String("SET expires = :expires"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":expires": {
+				N: aws.String(strconv.Itoa(int(expires.Unix()))),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(id)"),
+	}
+	_, err := s.Db.UpdateItemWithContext(ctx, updateInput)
+	return err
+}
+
+// takeLoaded returns (and forgets) what loadContext recorded for sessionID.
+func (s *DynamoDBStore) takeLoaded(sessionID string) (loadedSession, bool) {
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+	loaded, ok := s.loaded[sessionID]
+	delete(s.loaded, sessionID)
+	return loaded, ok
+}
+
+// pruneLoaded discards loadContext bookkeeping for sessions whose recorded
+// expiry has already passed. takeLoaded alone only forgets an entry once
+// that session is saved, so a session that is loaded but never saved again
+// (any read-only request) would otherwise sit in s.loaded forever; Cleanup
+// calls this on its own sweep schedule, and loadContext also calls
+// pruneLoadedLocked on every insert so the map stays bounded even when
+// BackgroundSweep is off and Cleanup is never invoked.
+func (s *DynamoDBStore) pruneLoaded() {
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+	s.pruneLoadedLocked()
+}
+
+// pruneLoadedLocked is pruneLoaded's body, factored out so loadContext can
+// prune while it already holds loadedMu rather than re-entering it.
+func (s *DynamoDBStore) pruneLoadedLocked() {
+	now := time.Now()
+	for id, loaded := range s.loaded {
+		if loaded.expires.Before(now) {
+			delete(s.loaded, id)
+		}
+	}
+}
+
 // load reads the session from DB.
 // returns true if there is a session data in DB
+//
+// This guards against stale rows even when native DynamoDB TTL is enabled,
+// since TTL deletion is a background process that can lag up to 48 hours
+// behind the "expires" timestamp.
 func (s *DynamoDBStore) load(session *sessions.Session) (bool, error) {
+	return s.loadContext(context.Background(), session)
+}
+
+// loadContext is like load but passes ctx through to the underlying
+// GetItem call.
+func (s *DynamoDBStore) loadContext(ctx context.Context, session *sessions.Session) (bool, error) {
 	getInput := &dynamodb.GetItemInput{
 		TableName: aws.String(s.Table),
 		Key: map[string]*dynamodb.AttributeValue{
@@ -255,7 +642,7 @@ func (s *DynamoDBStore) load(session *sessions.Session) (bool, error) {
 			},
 		},
 	}
-	item, err := s.Db.GetItem(getInput)
+	item, err := s.Db.GetItemWithContext(ctx, getInput)
 	if err != nil {
 		return false, err
 	}
@@ -277,11 +664,31 @@ func (s *DynamoDBStore) load(session *sessions.Session) (bool, error) {
 
 	}
 
-	return true, s.serializer.Deserialize(item.Item["data"].B, session)
+	data := item.Item["data"].B
+	if err := s.serializer.Deserialize(data, session); err != nil {
+		return true, err
+	}
+
+	if s.RefreshInterval > 0 {
+		if hash, err := stableHash(session.Values); err == nil {
+			s.loadedMu.Lock()
+			s.pruneLoadedLocked()
+			s.loaded[session.ID] = loadedSession{hash: hash, expires: expiresTime}
+			s.loadedMu.Unlock()
+		}
+	}
+
+	return true, nil
 }
 
 // deletes a session frmo dynamoDb
 func (s *DynamoDBStore) delete(sessionId string) error {
+	return s.deleteContext(context.Background(), sessionId)
+}
+
+// deleteContext is like delete but passes ctx through to the underlying
+// DeleteItem call.
+func (s *DynamoDBStore) deleteContext(ctx context.Context, sessionId string) error {
 	deleteInput := &dynamodb.DeleteItemInput{
 		TableName: aws.String(s.Table),
 		Key: map[string]*dynamodb.AttributeValue{
@@ -290,12 +697,24 @@ func (s *DynamoDBStore) delete(sessionId string) error {
 			},
 		},
 	}
-	_, err := s.Db.DeleteItem(deleteInput)
+	_, err := s.Db.DeleteItemWithContext(ctx, deleteInput)
 	return err
 }
 
-// scan session table and delete expires sessions
-func (s *DynamoDBStore) doBackgroundExpiration() {
+// Cleanup performs a single Scan of the session table, deleting any rows
+// whose "expires" has passed. It is safe to call concurrently with itself
+// and with normal store operations. Callers relying on native DynamoDB TTL
+// (see StoreOptions.EnableTTL) don't need to call this at all; it exists
+// for the cases TTL doesn't cover (TTL deletion can lag up to 48 hours, or
+// is disabled entirely).
+//
+// It also prunes loadContext's RefreshInterval bookkeeping (see
+// pruneLoaded) as a backstop; loadContext itself prunes on every insert,
+// so s.loaded stays bounded even if Cleanup/PeriodicCleanup never runs
+// (e.g. StoreOptions.BackgroundSweep is false).
+func (s *DynamoDBStore) Cleanup() {
+
+	s.pruneLoaded()
 
 	s.Db.ScanPages(&dynamodb.ScanInput{TableName: aws.String(s.Table)},
 		func(page *dynamodb.ScanOutput, lastPage bool) bool {
@@ -316,18 +735,35 @@ func (s *DynamoDBStore) doBackgroundExpiration() {
 
 }
 
-// run an expiration run immediately on startup (in the background) and then repeat every expireScanInterval
-func (s *DynamoDBStore) runBackgroundExpiration() {
+// PeriodicCleanup calls Cleanup every interval until quit is closed (or,
+// for the store's own internal sweep goroutine, forever if quit is nil).
+// Callers that want to stop the sweep on shutdown should pass their own
+// quit channel and close it; this replaces the old unstoppable
+// runBackgroundExpiration loop.
+//
+// As with the old loop, an initial Cleanup runs after a 10 second delay to
+// let the app finish starting up, rather than waiting for the first
+// interval to elapse.
+func (s *DynamoDBStore) PeriodicCleanup(interval time.Duration, quit <-chan struct{}) {
 
-	// run an initial deletion after a 10 second delay to let the app start up
 	go func() {
-		time.Sleep(10 * time.Second)
-		s.doBackgroundExpiration()
+		select {
+		case <-time.After(10 * time.Second):
+			s.Cleanup()
+		case <-quit:
+		}
 	}()
 
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
 	for {
-		<-time.Tick(expireScanInterval)
-		s.doBackgroundExpiration()
+		select {
+		case <-ticker.C:
+			s.Cleanup()
+		case <-quit:
+			return
+		}
 	}
 
 }
@@ -0,0 +1,333 @@
+// Copyright (C) 2016 Colin Nicholson
+//
+// This software may be modified and distributed under the terms
+// of the MIT license.  See the LICENSE file for details.
+
+package dynamodbstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// fakeDynamoAPI is an in-memory DynamoAPI good enough to exercise
+// DynamoDBStore without a live DynamoDB. It embeds dynamodbiface.DynamoDBAPI
+// so it satisfies DynamoAPI while only implementing the handful of methods
+// these tests exercise (ScanPages, used by Cleanup, is not implemented).
+type fakeDynamoAPI struct {
+	dynamodbiface.DynamoDBAPI
+
+	items map[string]map[string]*dynamodb.AttributeValue
+
+	putCalls    int
+	updateCalls int
+
+	// tableExists and ttlEnabled let tests drive tryCreateTable and
+	// enableTableTTL through their DescribeTable/CreateTable/
+	// UpdateTimeToLive calls without a live DynamoDB.
+	tableExists        bool
+	ttlEnabled         bool
+	describeTableCalls int
+	createTableCalls   int
+	updateTTLCalls     int
+	lastCreateTable    *dynamodb.CreateTableInput
+}
+
+func newFakeDynamoAPI() *fakeDynamoAPI {
+	return &fakeDynamoAPI{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+}
+
+func (f *fakeDynamoAPI) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	f.describeTableCalls++
+	if !f.tableExists {
+		return nil, awserr.New("ResourceNotFoundException", "no such table", nil)
+	}
+	return &dynamodb.DescribeTableOutput{}, nil
+}
+
+func (f *fakeDynamoAPI) CreateTable(input *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+	f.createTableCalls++
+	f.lastCreateTable = input
+	f.tableExists = true
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+func (f *fakeDynamoAPI) UpdateTimeToLive(input *dynamodb.UpdateTimeToLiveInput) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	f.updateTTLCalls++
+	if f.ttlEnabled {
+		return nil, awserr.New("ValidationException", "TTL already enabled", nil)
+	}
+	f.ttlEnabled = true
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
+
+func (f *fakeDynamoAPI) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	f.putCalls++
+	f.items[*input.Item["id"].S] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamoAPI) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	return f.PutItem(input)
+}
+
+func (f *fakeDynamoAPI) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: f.items[*input.Key["id"].S]}, nil
+}
+
+func (f *fakeDynamoAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	return f.GetItem(input)
+}
+
+func (f *fakeDynamoAPI) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	delete(f.items, *input.Key["id"].S)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (f *fakeDynamoAPI) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	return f.DeleteItem(input)
+}
+
+func (f *fakeDynamoAPI) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	f.updateCalls++
+	id := *input.Key["id"].S
+	item, ok := f.items[id]
+	if !ok {
+		// Mirrors DynamoDB's real behaviour for an UpdateItem with a
+		// ConditionExpression against a row that doesn't exist.
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "no such item", nil)
+	}
+	item["expires"] = input.ExpressionAttributeValues[":expires"]
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (f *fakeDynamoAPI) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	return f.UpdateItem(input)
+}
+
+func newTestStore(db DynamoAPI, refreshInterval time.Duration) *DynamoDBStore {
+	return &DynamoDBStore{
+		Db:              db,
+		Table:           "sessions",
+		Options:         &sessions.Options{Path: "/", MaxAge: sessionExpire},
+		DefaultMaxAge:   sessionExpire,
+		serializer:      GobSerializer{},
+		RefreshInterval: refreshInterval,
+		loaded:          make(map[string]loadedSession),
+	}
+}
+
+// TestSaveContextRefreshFastPath covers the bug reported against the
+// original implementation: re-Serialize()d bytes were compared against
+// the loaded blob's hash, which never matched for GobSerializer because
+// gob.Encode of a map walks it in randomized order. saveContext should
+// instead take the fast path (a single UpdateItem, no extra PutItem) for
+// an unmodified session, and fall back to a full rewrite once a value
+// actually changes.
+func TestSaveContextRefreshFastPath(t *testing.T) {
+	db := newFakeDynamoAPI()
+	store := newTestStore(db, time.Hour)
+
+	session := sessions.NewSession(store, "test")
+	session.ID = "abc"
+	session.Options = &sessions.Options{MaxAge: int((48 * time.Hour).Seconds())}
+	session.Values["user"] = "alice"
+	session.Values["role"] = "admin"
+
+	if err := store.saveContext(context.Background(), session); err != nil {
+		t.Fatalf("initial save: %v", err)
+	}
+	if db.putCalls != 1 {
+		t.Fatalf("expected 1 PutItem after initial save, got %d", db.putCalls)
+	}
+
+	loaded := sessions.NewSession(store, "test")
+	loaded.ID = "abc"
+	if ok, err := store.loadContext(context.Background(), loaded); err != nil || !ok {
+		t.Fatalf("load: ok=%v err=%v", ok, err)
+	}
+
+	loaded.Options = &sessions.Options{MaxAge: int((48 * time.Hour).Seconds())}
+	if err := store.saveContext(context.Background(), loaded); err != nil {
+		t.Fatalf("unchanged save: %v", err)
+	}
+	if db.putCalls != 1 {
+		t.Fatalf("expected fast path to skip PutItem, got %d total PutItem calls", db.putCalls)
+	}
+	if db.updateCalls != 1 {
+		t.Fatalf("expected fast path to issue 1 UpdateItem, got %d", db.updateCalls)
+	}
+
+	// Changing a value must force a full rewrite, not the fast path.
+	if ok, err := store.loadContext(context.Background(), loaded); err != nil || !ok {
+		t.Fatalf("reload: ok=%v err=%v", ok, err)
+	}
+	loaded.Values["role"] = "user"
+	if err := store.saveContext(context.Background(), loaded); err != nil {
+		t.Fatalf("changed save: %v", err)
+	}
+	if db.putCalls != 2 {
+		t.Fatalf("expected a changed value to force a full PutItem, got %d", db.putCalls)
+	}
+}
+
+// TestEncryptedSerializerRoundTrip checks that a session serialized through
+// NewEncryptedSerializer decodes back to the same Values. It encodes and
+// decodes straight away, so it does not exercise the codec MaxAge that
+// NewEncryptedSerializer disables.
+func TestEncryptedSerializerRoundTrip(t *testing.T) {
+	key := securecookie.GenerateRandomKey(32)
+	enc := NewEncryptedSerializer(GobSerializer{}, key)
+
+	session := sessions.NewSession(nil, "test")
+	session.Values["user"] = "alice"
+	session.Values["count"] = 7
+
+	b, err := enc.Serialize(session)
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+
+	got := sessions.NewSession(nil, "test")
+	if err := enc.Deserialize(b, got); err != nil {
+		t.Fatalf("Deserialize: %v", err)
+	}
+
+	if got.Values["user"] != "alice" || got.Values["count"] != 7 {
+		t.Fatalf("round trip mismatch: %#v", got.Values)
+	}
+}
+
+// TestSaveContextRefreshFastPathFallsBack covers the case where the row the
+// fast path expects to update has been deleted concurrently (e.g. the
+// session expired, or was signed out from elsewhere): saveContext must fall
+// back to a full PutItem rewrite rather than surfacing the resulting
+// ConditionalCheckFailedException to the caller.
+func TestSaveContextRefreshFastPathFallsBack(t *testing.T) {
+	db := newFakeDynamoAPI()
+	store := newTestStore(db, time.Hour)
+
+	session := sessions.NewSession(store, "test")
+	session.ID = "abc"
+	session.Options = &sessions.Options{MaxAge: int((48 * time.Hour).Seconds())}
+	session.Values["user"] = "alice"
+
+	if err := store.saveContext(context.Background(), session); err != nil {
+		t.Fatalf("initial save: %v", err)
+	}
+
+	loaded := sessions.NewSession(store, "test")
+	loaded.ID = "abc"
+	loaded.Options = &sessions.Options{MaxAge: int((48 * time.Hour).Seconds())}
+	if ok, err := store.loadContext(context.Background(), loaded); err != nil || !ok {
+		t.Fatalf("load: ok=%v err=%v", ok, err)
+	}
+
+	// Simulate the row disappearing between the load above and the save
+	// below, so the fast path's conditional UpdateItem fails.
+	delete(db.items, "abc")
+
+	if err := store.saveContext(context.Background(), loaded); err != nil {
+		t.Fatalf("save after concurrent delete should fall back, not fail: %v", err)
+	}
+	if db.putCalls != 2 {
+		t.Fatalf("expected the fallback to issue a PutItem, got %d total PutItem calls", db.putCalls)
+	}
+	if _, ok := db.items["abc"]; !ok {
+		t.Fatalf("expected the fallback PutItem to recreate the row")
+	}
+}
+
+// TestTryCreateTableBillingModeAndTTL covers the three things tryCreateTable
+// and enableTableTTL do beyond a plain CreateTable call: choosing
+// provisioned throughput vs. on-demand billing, and tolerating TTL already
+// being enabled on repeated calls.
+func TestTryCreateTableBillingModeAndTTL(t *testing.T) {
+	db := newFakeDynamoAPI()
+	options := StoreOptions{
+		ReadCapacityUnits:  5,
+		WriteCapacityUnits: 5,
+		EnableTTL:          true,
+	}
+
+	if err := tryCreateTable(db, "sessions", options); err != nil {
+		t.Fatalf("tryCreateTable: %v", err)
+	}
+	if db.createTableCalls != 1 {
+		t.Fatalf("expected CreateTable to be called once, got %d", db.createTableCalls)
+	}
+	if db.lastCreateTable.BillingMode != nil {
+		t.Fatalf("expected provisioned throughput, got BillingMode %v", *db.lastCreateTable.BillingMode)
+	}
+	if got := *db.lastCreateTable.ProvisionedThroughput.ReadCapacityUnits; got != 5 {
+		t.Fatalf("expected ReadCapacityUnits 5, got %d", got)
+	}
+	if db.updateTTLCalls != 1 || !db.ttlEnabled {
+		t.Fatalf("expected enableTableTTL to have enabled TTL, updateTTLCalls=%d ttlEnabled=%v", db.updateTTLCalls, db.ttlEnabled)
+	}
+
+	// Table now exists: a second call must not try to create it again, and
+	// must tolerate TTL already being enabled.
+	if err := tryCreateTable(db, "sessions", options); err != nil {
+		t.Fatalf("tryCreateTable (table exists): %v", err)
+	}
+	if db.createTableCalls != 1 {
+		t.Fatalf("expected CreateTable not to be called again, got %d total calls", db.createTableCalls)
+	}
+
+	payPerRequestDB := newFakeDynamoAPI()
+	if err := tryCreateTable(payPerRequestDB, "sessions", StoreOptions{BillingMode: dynamodb.BillingModePayPerRequest}); err != nil {
+		t.Fatalf("tryCreateTable (pay-per-request): %v", err)
+	}
+	if payPerRequestDB.lastCreateTable.ProvisionedThroughput != nil {
+		t.Fatalf("expected no ProvisionedThroughput in pay-per-request mode")
+	}
+	if got := *payPerRequestDB.lastCreateTable.BillingMode; got != dynamodb.BillingModePayPerRequest {
+		t.Fatalf("expected BillingMode %q, got %q", dynamodb.BillingModePayPerRequest, got)
+	}
+}
+
+// TestJSONSerializer covers JSONSerializer's round trip and its one
+// documented failure mode: a non-string key can't be represented as a JSON
+// object key.
+func TestJSONSerializer(t *testing.T) {
+	ser := JSONSerializer{}
+
+	session := sessions.NewSession(nil, "test")
+	session.Values["user"] = "alice"
+	session.Values["count"] = 7
+
+	b, err := ser.Serialize(session)
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+
+	got := sessions.NewSession(nil, "test")
+	if err := ser.Deserialize(b, got); err != nil {
+		t.Fatalf("Deserialize: %v", err)
+	}
+
+	if got.Values["user"] != "alice" {
+		t.Fatalf("expected user %q, got %v", "alice", got.Values["user"])
+	}
+	// JSON has no integer type: a number round trips as float64, not int.
+	if got.Values["count"] != float64(7) {
+		t.Fatalf("expected count to widen to float64(7), got %#v", got.Values["count"])
+	}
+
+	bad := sessions.NewSession(nil, "test")
+	bad.Values[42] = "not a string key"
+	if _, err := ser.Serialize(bad); err == nil {
+		t.Fatalf("expected Serialize to reject a non-string key")
+	}
+}